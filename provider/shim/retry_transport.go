@@ -0,0 +1,198 @@
+package shim
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+// Defaults for retryTransport, used when the provider's retry_* schema
+// fields are left unset.
+const (
+	defaultRetryMaxAttempts = 5
+	defaultRetryBaseBackoff = 500 * time.Millisecond
+	defaultRetryMaxBackoff  = 30 * time.Second
+)
+
+// defaultRetryStatusCodes are the response codes retried when
+// retry_status_codes is not configured: rate limiting and the gateway
+// errors that typically indicate a transient upstream problem.
+var defaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// retryConfig is the parsed retry_* provider schema, consumed by
+// configureHTTPTransport to build a retryTransport.
+type retryConfig struct {
+	maxAttempts        int
+	maxBackoff         time.Duration
+	statusCodes        map[int]struct{}
+	retryNonIdempotent bool
+}
+
+// parseRetryConfig reads the retry_max_attempts, retry_max_backoff,
+// retry_status_codes, and retry_non_idempotent schema fields. All have
+// defaults, so this never errors and always returns a usable config.
+func parseRetryConfig(d *schema.ResourceData) retryConfig {
+	cfg := retryConfig{
+		maxAttempts: defaultRetryMaxAttempts,
+		maxBackoff:  defaultRetryMaxBackoff,
+	}
+
+	if v, ok := d.GetOk("retry_max_attempts"); ok {
+		cfg.maxAttempts = v.(int)
+	}
+	if v, ok := d.GetOk("retry_max_backoff"); ok {
+		cfg.maxBackoff = time.Duration(v.(int)) * time.Second
+	}
+	if v, ok := d.GetOk("retry_non_idempotent"); ok {
+		cfg.retryNonIdempotent = v.(bool)
+	}
+
+	codes := defaultRetryStatusCodes
+	if v, ok := d.GetOk("retry_status_codes"); ok {
+		raw := v.([]interface{})
+		codes = make([]int, 0, len(raw))
+		for _, c := range raw {
+			codes = append(codes, c.(int))
+		}
+	}
+	cfg.statusCodes = make(map[int]struct{}, len(codes))
+	for _, c := range codes {
+		cfg.statusCodes[c] = struct{}{}
+	}
+
+	return cfg
+}
+
+// idempotentMethods are HTTP methods retryTransport retries without
+// requiring an Idempotency-Key echo or retry_non_idempotent opt-in.
+var idempotentMethods = map[string]struct{}{
+	http.MethodGet:     {},
+	http.MethodHead:    {},
+	http.MethodPut:     {},
+	http.MethodDelete:  {},
+	http.MethodOptions: {},
+	http.MethodTrace:   {},
+}
+
+// retryTransport retries requests that receive a rate-limited or
+// transiently-failing response, honoring Retry-After when the server sends
+// one and falling back to exponential backoff with jitter otherwise.
+type retryTransport struct {
+	base               http.RoundTripper
+	maxAttempts        int
+	maxBackoff         time.Duration
+	statusCodes        map[int]struct{}
+	retryNonIdempotent bool
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+
+	for attempt := 1; attempt <= t.maxAttempts; attempt++ {
+		attemptReq := req
+		if attempt > 1 {
+			attemptReq = req.Clone(req.Context())
+			if req.Body != nil {
+				if req.GetBody == nil {
+					return nil, fmt.Errorf("cannot retry %s %s: request body is not replayable (no GetBody)", req.Method, req.URL)
+				}
+				body, bodyErr := req.GetBody()
+				if bodyErr != nil {
+					return nil, fmt.Errorf("rewinding request body to retry %s %s: %w", req.Method, req.URL, bodyErr)
+				}
+				attemptReq.Body = body
+			}
+		}
+
+		resp, err = t.base.RoundTrip(attemptReq)
+		if err != nil {
+			return nil, err
+		}
+
+		if attempt == t.maxAttempts || !t.shouldRetry(attemptReq, resp) {
+			return resp, nil
+		}
+
+		delay := t.retryDelay(resp, attempt)
+		resp.Body.Close()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+
+	return resp, err
+}
+
+func (t *retryTransport) shouldRetry(req *http.Request, resp *http.Response) bool {
+	if _, ok := t.statusCodes[resp.StatusCode]; !ok {
+		return false
+	}
+	if _, ok := idempotentMethods[req.Method]; ok {
+		return true
+	}
+	if t.retryNonIdempotent {
+		return true
+	}
+	if key := req.Header.Get("Idempotency-Key"); key != "" && resp.Header.Get("Idempotency-Key") == key {
+		return true
+	}
+	return false
+}
+
+// retryDelay honors the response's Retry-After header (delta-seconds or an
+// HTTP-date), falling back to exponential backoff with full jitter, capped
+// at maxBackoff.
+func (t *retryTransport) retryDelay(resp *http.Response, attempt int) time.Duration {
+	if ra := resp.Header.Get("Retry-After"); ra != "" {
+		if secs, err := strconv.Atoi(ra); err == nil {
+			return capDuration(time.Duration(secs)*time.Second, t.maxBackoff)
+		}
+		if when, err := http.ParseTime(ra); err == nil {
+			if d := time.Until(when); d > 0 {
+				return capDuration(d, t.maxBackoff)
+			}
+			return 0
+		}
+	}
+
+	backoff := exponentialBackoff(defaultRetryBaseBackoff, t.maxBackoff, attempt)
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// exponentialBackoff doubles base (attempt-1) times, capped at max. It
+// doubles iteratively rather than via base<<uint(attempt-1): retry_max_attempts
+// is user-configurable with no upper bound, and a large enough attempt count
+// would shift past the width of the integer and silently wrap to 0, turning
+// the cap into zero-backoff rapid-fire retries instead of respecting it.
+func exponentialBackoff(base, max time.Duration, attempt int) time.Duration {
+	backoff := base
+	for i := 1; i < attempt && backoff < max; i++ {
+		backoff *= 2
+		if backoff <= 0 {
+			return max
+		}
+	}
+	return capDuration(backoff, max)
+}
+
+func capDuration(d, max time.Duration) time.Duration {
+	if d > max {
+		return max
+	}
+	return d
+}
@@ -3,52 +3,428 @@
 package shim
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"path"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
 	"github.com/rancher/terraform-provider-rancher2/rancher2"
 )
 
+// fileHeaderCacheTTL bounds how long an unchanged mtime is trusted before
+// fileHeaderCache.read re-reads a file regardless, as a fallback for secret
+// rotation sidecars (e.g. a Vault agent) that rewrite a file's contents
+// without bumping its mtime.
+const fileHeaderCacheTTL = 5 * time.Second
+
+// Defaults for the http.Transport cloneDefaultTransport falls back to
+// building by hand, mirroring net/http's own http.DefaultTransport values,
+// for the rare case http.DefaultTransport isn't an *http.Transport to clone.
+const (
+	defaultMaxIdleConns          = 100
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultDialTimeout           = 30 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultExpectContinueTimeout = 1 * time.Second
+)
+
 var (
-	// extraHeaders stores the custom headers to be added to all requests
-	extraHeaders     map[string]string
+	// extraHeaders stores the custom headers to be added to all requests.
+	// Values are slices to support multiple values for the same header key
+	// (e.g. multiple Cookie or X-Forwarded-* values), added via Header.Add.
+	extraHeaders     map[string][]string
 	extraHeadersLock sync.RWMutex
+
+	// extraHeaderFiles stores, for each header key, the path of a file whose
+	// trimmed contents should be used as the header value.
+	extraHeaderFiles     map[string]string
+	extraHeaderFilesLock sync.RWMutex
+
+	// extraHeaderRules stores per-endpoint header rules, applied in
+	// declaration order on top of extraHeaders.
+	extraHeaderRules     []headerRule
+	extraHeaderRulesLock sync.RWMutex
+
+	// allowReservedHeaders opts out of the reserved-header guard in
+	// checkReservedHeaders. See AllowReservedHeaders.
+	allowReservedHeaders     bool
+	allowReservedHeadersLock sync.RWMutex
+
+	// extraTokenProvider holds the configured dynamic bearer token source,
+	// if any. See SetTokenProvider.
+	extraTokenProvider     *tokenProviderConfig
+	extraTokenProviderLock sync.RWMutex
+
+	// configureHTTPTransportMu serializes the window from building a
+	// provider instance's custom http.DefaultTransport/http.DefaultClient
+	// through handing control back to the upstream ConfigureFunc.
+	// http.DefaultTransport/http.DefaultClient are process-global, so two
+	// aliased rancher2 provider instances configuring concurrently (the
+	// multi-cluster scenario mTLS/token_provider target) could otherwise
+	// interleave and pick up each other's certificates or tokens. This
+	// only prevents that interleaving; it does not give each instance its
+	// own transport, since the upstream client is built from the global.
+	configureHTTPTransportMu sync.Mutex
 )
 
+// reservedHeaders are header keys that headerTransport.RoundTrip must not
+// let user-supplied extra headers clobber, because doing so would silently
+// override the upstream rancher2 provider's own authentication on outbound
+// requests. Keys are stored in canonical http.CanonicalHeaderKey form.
+var reservedHeaders = map[string]struct{}{
+	"Authorization":       {},
+	"Proxy-Authorization": {},
+	"Cookie":              {},
+	"Host":                {},
+	"Content-Length":      {},
+	"X-Api-Auth-Token":    {},
+	"X-Api-Tunnel-Token":  {},
+	"X-Api-Cattleid":      {},
+}
+
+// AllowReservedHeaders opts in (or, passed false, back out) of permitting
+// SetExtraHeaders, SetExtraHeaderFiles, and ParseHeadersString to set
+// reserved headers such as Authorization or Cookie. This is an escape hatch
+// for advanced users who intentionally want to override Rancher's own
+// credentials; by default those calls refuse such configs.
+func AllowReservedHeaders(allow bool) {
+	allowReservedHeadersLock.Lock()
+	defer allowReservedHeadersLock.Unlock()
+	allowReservedHeaders = allow
+}
+
+func reservedHeadersAllowed() bool {
+	allowReservedHeadersLock.RLock()
+	defer allowReservedHeadersLock.RUnlock()
+	return allowReservedHeaders
+}
+
+// checkReservedHeaders returns an error naming any keys in headers that
+// collide with reservedHeaders, unless reserved headers have been allowed
+// via AllowReservedHeaders.
+func checkReservedHeaders(headers map[string]string) error {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	return checkReservedHeaderKeys(keys)
+}
+
+// checkReservedMultiHeaders is checkReservedHeaders for the multi-value
+// header maps used by extraHeaders and headerRule.headers.
+func checkReservedMultiHeaders(headers map[string][]string) error {
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	return checkReservedHeaderKeys(keys)
+}
+
+func checkReservedHeaderKeys(keys []string) error {
+	if reservedHeadersAllowed() {
+		return nil
+	}
+	var reserved []string
+	for _, key := range keys {
+		if _, ok := reservedHeaders[http.CanonicalHeaderKey(key)]; ok {
+			reserved = append(reserved, key)
+		}
+	}
+	if len(reserved) == 0 {
+		return nil
+	}
+	sort.Strings(reserved)
+	return fmt.Errorf("refusing to set reserved header(s) %s: these would override Rancher's own authentication; set extra_headers_allow_reserved to opt in", strings.Join(reserved, ", "))
+}
+
 // headerTransport wraps an http.RoundTripper to add custom headers
 type headerTransport struct {
-	base    http.RoundTripper
-	headers map[string]string
+	base        http.RoundTripper
+	headers     map[string][]string
+	headerFiles map[string]string
+	rules       []headerRule
+	fileCache   fileHeaderCache
+
+	// tokenProvider, if set, injects a dynamic bearer/access token on every
+	// request, refreshed ahead of its expiry. See currentToken.
+	tokenProvider *tokenProviderConfig
+	tokenMu       sync.Mutex
+	tokenValue    string
+	tokenExpiry   time.Time
+}
+
+// headerRule adds extra headers only to requests matching all of its
+// filters. A zero-value filter (no hosts, no path prefix, no methods)
+// matches every request. Rules are applied in declaration order, after the
+// transport's global headers, so a rule's values are added alongside (not
+// instead of) the global ones.
+type headerRule struct {
+	// hosts are glob patterns (see path.Match) matched against req.URL.Host.
+	// No hosts means the rule matches every host.
+	hosts []string
+	// pathPrefix, if set, must prefix req.URL.Path.
+	pathPrefix string
+	// methods are the HTTP methods the rule applies to. No methods means the
+	// rule applies to every method.
+	methods []string
+	headers map[string][]string
+}
+
+func (r *headerRule) matches(req *http.Request) bool {
+	if len(r.methods) > 0 {
+		methodMatched := false
+		for _, method := range r.methods {
+			if strings.EqualFold(method, req.Method) {
+				methodMatched = true
+				break
+			}
+		}
+		if !methodMatched {
+			return false
+		}
+	}
+
+	if r.pathPrefix != "" && !strings.HasPrefix(req.URL.Path, r.pathPrefix) {
+		return false
+	}
+
+	if len(r.hosts) > 0 {
+		hostMatched := false
+		for _, hostGlob := range r.hosts {
+			if ok, _ := path.Match(hostGlob, req.URL.Host); ok {
+				hostMatched = true
+				break
+			}
+			if ok, _ := path.Match(hostGlob, req.URL.Hostname()); ok {
+				hostMatched = true
+				break
+			}
+		}
+		if !hostMatched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fileHeaderCache memoizes the trimmed contents of header value files,
+// keyed by path and invalidated by mtime, so a rotated secret is picked up
+// as soon as RoundTrip's next stat observes a changed mtime rather than
+// waiting out a flat TTL. fileHeaderCacheTTL still bounds the cache: an
+// unchanged mtime is only trusted for that long, so a rotation sidecar that
+// rewrites a file in place without changing its mtime (or a clock/filesystem
+// with coarse mtime resolution) still converges.
+type fileHeaderCache struct {
+	mu      sync.Mutex
+	entries map[string]fileHeaderCacheEntry
+}
+
+type fileHeaderCacheEntry struct {
+	value    string
+	modTime  time.Time
+	cachedAt time.Time
+}
+
+func (c *fileHeaderCache) read(path string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]fileHeaderCacheEntry)
+	}
+
+	entry, hasEntry := c.entries[path]
+	now := time.Now()
+	withinTTL := hasEntry && now.Before(entry.cachedAt.Add(fileHeaderCacheTTL))
+
+	info, statErr := os.Stat(path)
+	if withinTTL && statErr == nil && info.ModTime().Equal(entry.modTime) {
+		return entry.value, nil
+	}
+	if withinTTL && statErr != nil {
+		// Stat failed - e.g. a rotation sidecar briefly unlinking the file
+		// before recreating it - but the cached value is still within its
+		// TTL: serve it rather than failing the request over what's likely
+		// a transient filesystem hiccup.
+		return entry.value, nil
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		if withinTTL {
+			return entry.value, nil
+		}
+		return "", fmt.Errorf("reading header value file %q: %w", path, err)
+	}
+
+	value := strings.TrimSpace(string(contents))
+	newEntry := fileHeaderCacheEntry{value: value, cachedAt: now}
+	if statErr == nil {
+		newEntry.modTime = info.ModTime()
+	}
+	c.entries[path] = newEntry
+	return value, nil
 }
 
 func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	// Clone the request to avoid modifying the original
 	reqClone := req.Clone(req.Context())
-	for key, value := range t.headers {
+
+	// Merge global headers first, using Add semantics so a key with
+	// multiple configured values (e.g. Cookie) is sent as multiple values.
+	for key, values := range t.headers {
+		for _, value := range values {
+			reqClone.Header.Add(key, value)
+		}
+	}
+	for key, filePath := range t.headerFiles {
+		value, err := t.fileCache.read(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("resolving header %q from file: %w", key, err)
+		}
 		reqClone.Header.Set(key, value)
 	}
+
+	// Then layer on any matching per-endpoint rules, in declaration order.
+	for _, rule := range t.rules {
+		if !rule.matches(reqClone) {
+			continue
+		}
+		for key, values := range rule.headers {
+			for _, value := range values {
+				reqClone.Header.Add(key, value)
+			}
+		}
+	}
+
+	// Finally, apply a dynamic token provider, if configured. Unless it
+	// opts out via override=false, the token provider's value wins over
+	// whatever a static header/rule above already set.
+	if t.tokenProvider != nil {
+		if t.tokenProvider.override || reqClone.Header.Get(t.tokenProvider.header) == "" {
+			value, err := t.currentToken(reqClone.Context())
+			if err != nil {
+				return nil, err
+			}
+			reqClone.Header.Set(t.tokenProvider.header, value)
+		}
+	}
+
 	return t.base.RoundTrip(reqClone)
 }
 
-// SetExtraHeaders sets the custom headers to be added to all requests
-func SetExtraHeaders(headers map[string]string) {
+// currentToken returns the cached token value, refreshing it via
+// tokenProvider.source.Token if it's within tokenProvider.skew of expiring
+// or hasn't been fetched yet.
+func (t *headerTransport) currentToken(ctx context.Context) (string, error) {
+	t.tokenMu.Lock()
+	defer t.tokenMu.Unlock()
+
+	if t.tokenValue != "" && time.Now().Before(t.tokenExpiry.Add(-t.tokenProvider.skew)) {
+		return t.tokenValue, nil
+	}
+
+	value, expiry, err := t.tokenProvider.source.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("refreshing token_provider token: %w", err)
+	}
+	t.tokenValue = value
+	t.tokenExpiry = expiry
+	return value, nil
+}
+
+// SetExtraHeaders sets the custom headers to be added to all requests. It
+// returns an error, leaving the previously stored headers untouched, if
+// headers contains a reserved key and reserved headers have not been
+// allowed via AllowReservedHeaders.
+func SetExtraHeaders(headers map[string][]string) error {
+	if err := checkReservedMultiHeaders(headers); err != nil {
+		return err
+	}
 	extraHeadersLock.Lock()
 	defer extraHeadersLock.Unlock()
 	extraHeaders = headers
+	return nil
 }
 
 // GetExtraHeaders returns the current custom headers
-func GetExtraHeaders() map[string]string {
+func GetExtraHeaders() map[string][]string {
 	extraHeadersLock.RLock()
 	defer extraHeadersLock.RUnlock()
 	return extraHeaders
 }
 
+// SetExtraHeaderRules sets the per-endpoint header rules applied on top of
+// the global extra headers. It returns an error, leaving the previous rules
+// untouched, if any rule sets a reserved key and reserved headers have not
+// been allowed via AllowReservedHeaders.
+func SetExtraHeaderRules(rules []headerRule) error {
+	for _, rule := range rules {
+		if err := checkReservedMultiHeaders(rule.headers); err != nil {
+			return err
+		}
+	}
+	extraHeaderRulesLock.Lock()
+	defer extraHeaderRulesLock.Unlock()
+	extraHeaderRules = rules
+	return nil
+}
+
+// GetExtraHeaderRules returns the current per-endpoint header rules
+func GetExtraHeaderRules() []headerRule {
+	extraHeaderRulesLock.RLock()
+	defer extraHeaderRulesLock.RUnlock()
+	return extraHeaderRules
+}
+
+// SetExtraHeaderFiles sets the header keys whose values are sourced from
+// files on disk, re-read on a TTL so rotated secrets are picked up without
+// restarting Pulumi. It returns an error, leaving the previous state
+// untouched, if files contains a reserved key and reserved headers have not
+// been allowed via AllowReservedHeaders.
+func SetExtraHeaderFiles(files map[string]string) error {
+	if err := checkReservedHeaders(files); err != nil {
+		return err
+	}
+	extraHeaderFilesLock.Lock()
+	defer extraHeaderFilesLock.Unlock()
+	extraHeaderFiles = files
+	return nil
+}
+
+// GetExtraHeaderFiles returns the current file-backed header paths
+func GetExtraHeaderFiles() map[string]string {
+	extraHeaderFilesLock.RLock()
+	defer extraHeaderFilesLock.RUnlock()
+	return extraHeaderFiles
+}
+
+// SetTokenProvider sets the dynamic bearer token source applied on top of
+// the static extra headers/rules. Pass nil to disable it.
+func SetTokenProvider(cfg *tokenProviderConfig) {
+	extraTokenProviderLock.Lock()
+	defer extraTokenProviderLock.Unlock()
+	extraTokenProvider = cfg
+}
+
+// GetTokenProvider returns the current dynamic bearer token source, or nil.
+func GetTokenProvider() *tokenProviderConfig {
+	extraTokenProviderLock.RLock()
+	defer extraTokenProviderLock.RUnlock()
+	return extraTokenProvider
+}
+
 // Provider returns the upstream rancher2 provider with extra_headers support
 func Provider() *schema.Provider {
 	// Get the upstream provider
@@ -58,38 +434,349 @@ func Provider() *schema.Provider {
 	upstream.Schema["extra_headers"] = &schema.Schema{
 		Type:        schema.TypeMap,
 		Optional:    true,
-		Description: "Extra HTTP headers to include in all API requests to the Rancher server. Useful for proxies or firewalls. Can also be set via RANCHER_EXTRA_HEADERS environment variable as a JSON object.",
+		Description: "Extra HTTP headers to include in all API requests to the Rancher server. Useful for proxies or firewalls. One value per key; use extra_headers_multi for headers that need more than one value. Can also be set via RANCHER_EXTRA_HEADERS environment variable as a JSON object.",
 		DefaultFunc: schema.EnvDefaultFunc("RANCHER_EXTRA_HEADERS", nil),
 		Elem: &schema.Schema{
 			Type: schema.TypeString,
 		},
 	}
 
+	// Add extra_headers_multi schema field: for headers that need more than
+	// one value (e.g. multiple Cookie or X-Forwarded-* values), which a
+	// TypeMap can't represent since it only holds one value per key. A key
+	// may not appear in both extra_headers and extra_headers_multi.
+	upstream.Schema["extra_headers_multi"] = &schema.Schema{
+		Type:        schema.TypeList,
+		Optional:    true,
+		Description: "Extra HTTP headers that need more than one value. A header key may not appear in both extra_headers and extra_headers_multi.",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"name": {
+					Type:        schema.TypeString,
+					Required:    true,
+					Description: "The header name.",
+				},
+				"values": {
+					Type:        schema.TypeList,
+					Required:    true,
+					Description: "The values to send for this header, each added via Header.Add so all are sent.",
+					Elem:        &schema.Schema{Type: schema.TypeString},
+				},
+			},
+		},
+	}
+
+	// Add extra_headers_files schema field: same shape as extra_headers, but
+	// each value is a filesystem path whose trimmed contents are used as the
+	// header value, re-read periodically so rotated secrets take effect
+	// without restarting Pulumi.
+	upstream.Schema["extra_headers_files"] = &schema.Schema{
+		Type:        schema.TypeMap,
+		Optional:    true,
+		Description: "Extra HTTP headers whose values are read from files on disk, re-read periodically so rotated secrets (e.g. a Vault agent sidecar) take effect without restarting Pulumi. A header key may not appear in more than one of extra_headers, extra_headers_multi, or extra_headers_files. Can also be set via RANCHER_EXTRA_HEADERS_FILES environment variable as a JSON object.",
+		DefaultFunc: schema.EnvDefaultFunc("RANCHER_EXTRA_HEADERS_FILES", nil),
+		Elem: &schema.Schema{
+			Type: schema.TypeString,
+		},
+	}
+
+	// Add extra_headers_allow_reserved schema field: by default, extra_headers
+	// and extra_headers_files refuse to set headers (Authorization, Cookie,
+	// Rancher's own X-API-* auth headers, ...) that would silently override
+	// the credentials the upstream provider sends. Advanced users who
+	// intentionally want that override can opt in.
+	upstream.Schema["extra_headers_allow_reserved"] = &schema.Schema{
+		Type:        schema.TypeBool,
+		Optional:    true,
+		Default:     false,
+		Description: "Allow extra_headers/extra_headers_files to set reserved headers (Authorization, Cookie, Host, Rancher's own auth headers, ...) that would otherwise silently override the upstream provider's own credentials. Defaults to false.",
+	}
+
+	// Add extra_header_rules schema field: per-endpoint header rules, for
+	// when different headers need to go to the Rancher API vs. downstream
+	// cluster endpoints reached through the Rancher proxy, or when a header
+	// needs more than one value (combined with extra_headers via the same
+	// key, since HTTP headers may repeat).
+	upstream.Schema["extra_header_rules"] = &schema.Schema{
+		Type:        schema.TypeList,
+		Optional:    true,
+		Description: "Per-endpoint extra HTTP header rules, applied on top of extra_headers/extra_headers_files in declaration order when a request matches the rule's hosts/path_prefix/methods filters.",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"hosts": {
+					Type:        schema.TypeList,
+					Optional:    true,
+					Description: "Glob patterns (see Go's path.Match) matched against the request host. Omit to match every host.",
+					Elem:        &schema.Schema{Type: schema.TypeString},
+				},
+				"path_prefix": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Description: "Require the request path to start with this prefix. Omit to match every path.",
+				},
+				"methods": {
+					Type:        schema.TypeList,
+					Optional:    true,
+					Description: "HTTP methods (e.g. GET, POST) this rule applies to. Omit to match every method.",
+					Elem:        &schema.Schema{Type: schema.TypeString},
+				},
+				"headers": {
+					Type:        schema.TypeMap,
+					Optional:    true,
+					Description: "Headers to add when this rule matches. One value per key; use headers_multi for headers that need more than one value. At least one of headers/headers_multi is required.",
+					Elem:        &schema.Schema{Type: schema.TypeString},
+				},
+				"headers_multi": {
+					Type:        schema.TypeList,
+					Optional:    true,
+					Description: "Headers to add when this rule matches, for headers that need more than one value. A header key may not appear in both headers and headers_multi on the same rule.",
+					Elem: &schema.Resource{
+						Schema: map[string]*schema.Schema{
+							"name": {
+								Type:        schema.TypeString,
+								Required:    true,
+								Description: "The header name.",
+							},
+							"values": {
+								Type:        schema.TypeList,
+								Required:    true,
+								Description: "The values to send for this header, each added via Header.Add so all are sent.",
+								Elem:        &schema.Schema{Type: schema.TypeString},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// Add mutual TLS schema fields. A client certificate/key pair can be
+	// supplied inline as PEM or as a file path; exactly one form should be
+	// used. Can also be set via RANCHER_CLIENT_CERT, RANCHER_CLIENT_KEY and
+	// RANCHER_CA_CERT environment variables as PEM content.
+	upstream.Schema["client_cert_pem"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "PEM-encoded client certificate to present for mutual TLS. Must be paired with client_key_pem. Can also be set via RANCHER_CLIENT_CERT.",
+		DefaultFunc: schema.EnvDefaultFunc("RANCHER_CLIENT_CERT", nil),
+	}
+	upstream.Schema["client_key_pem"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Sensitive:   true,
+		Description: "PEM-encoded private key to present for mutual TLS. Must be paired with client_cert_pem. Can also be set via RANCHER_CLIENT_KEY.",
+		DefaultFunc: schema.EnvDefaultFunc("RANCHER_CLIENT_KEY", nil),
+	}
+	upstream.Schema["client_cert_file"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "Path to a PEM-encoded client certificate to present for mutual TLS. Must be paired with client_key_file. Mutually exclusive with client_cert_pem.",
+	}
+	upstream.Schema["client_key_file"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "Path to a PEM-encoded private key to present for mutual TLS. Must be paired with client_cert_file. Mutually exclusive with client_key_pem.",
+	}
+	upstream.Schema["ca_cert_pem"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "PEM-encoded CA certificate(s) to trust in addition to the system pool when verifying the Rancher server's certificate. Can also be set via RANCHER_CA_CERT.",
+		DefaultFunc: schema.EnvDefaultFunc("RANCHER_CA_CERT", nil),
+	}
+	upstream.Schema["ca_cert_file"] = &schema.Schema{
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "Path to a PEM-encoded CA certificate to trust in addition to the system pool when verifying the Rancher server's certificate. Mutually exclusive with ca_cert_pem.",
+	}
+
+	// Add the token_provider schema block: a dynamic bearer/access token,
+	// refreshed ahead of its expiry, for fronting Rancher with Cloudflare
+	// Access, an OIDC-protected ingress, or a similar auth proxy.
+	upstream.Schema["token_provider"] = &schema.Schema{
+		Type:        schema.TypeList,
+		Optional:    true,
+		MaxItems:    1,
+		Description: "Configures a dynamic bearer/access token injected on every request and refreshed ahead of its expiry. Useful when Rancher sits behind Cloudflare Access, an OIDC-protected ingress, or a similar auth proxy.",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"type": {
+					Type:        schema.TypeString,
+					Required:    true,
+					Description: "Which token source to use: cf_access, oidc_client_credentials, or exec.",
+				},
+				"header": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Description: "Header the token value is written to. Defaults to Cf-Access-Token for cf_access, and Authorization for oidc_client_credentials/exec.",
+				},
+				"override": {
+					Type:        schema.TypeBool,
+					Optional:    true,
+					Default:     true,
+					Description: "Whether the token provider's value wins over a same-named value already set by extra_headers/extra_header_rules. Set to false to let the static value win instead.",
+				},
+				"refresh_skew_seconds": {
+					Type:        schema.TypeInt,
+					Optional:    true,
+					Default:     60,
+					Description: "How many seconds before the token's expiry to proactively refresh it.",
+				},
+				"cf_access": {
+					Type:        schema.TypeList,
+					Optional:    true,
+					MaxItems:    1,
+					Description: "Settings for type = \"cf_access\": fetches a token by running `cloudflared access token --app=<app_url>`.",
+					Elem: &schema.Resource{
+						Schema: map[string]*schema.Schema{
+							"app_url": {
+								Type:        schema.TypeString,
+								Required:    true,
+								Description: "The Cloudflare Access application URL to request a token for.",
+							},
+						},
+					},
+				},
+				"oidc_client_credentials": {
+					Type:        schema.TypeList,
+					Optional:    true,
+					MaxItems:    1,
+					Description: "Settings for type = \"oidc_client_credentials\": an RFC 6749 client_credentials grant.",
+					Elem: &schema.Resource{
+						Schema: map[string]*schema.Schema{
+							"token_url": {
+								Type:        schema.TypeString,
+								Required:    true,
+								Description: "The OIDC provider's token endpoint.",
+							},
+							"client_id": {
+								Type:        schema.TypeString,
+								Required:    true,
+								Description: "The OAuth2 client ID.",
+							},
+							"client_secret": {
+								Type:        schema.TypeString,
+								Required:    true,
+								Sensitive:   true,
+								Description: "The OAuth2 client secret.",
+							},
+							"scope": {
+								Type:        schema.TypeString,
+								Optional:    true,
+								Description: "Space-separated scopes to request. Omit to use the provider's default.",
+							},
+						},
+					},
+				},
+				"exec": {
+					Type:        schema.TypeList,
+					Optional:    true,
+					MaxItems:    1,
+					Description: "Settings for type = \"exec\": runs a command and uses its trimmed stdout as the token.",
+					Elem: &schema.Resource{
+						Schema: map[string]*schema.Schema{
+							"command": {
+								Type:        schema.TypeString,
+								Required:    true,
+								Description: "The command to run.",
+							},
+							"args": {
+								Type:        schema.TypeList,
+								Optional:    true,
+								Description: "Arguments to pass to command.",
+								Elem:        &schema.Schema{Type: schema.TypeString},
+							},
+							"ttl_seconds": {
+								Type:        schema.TypeInt,
+								Optional:    true,
+								Default:     int(defaultTokenTTL / time.Second),
+								Description: "How long a token returned by command is considered valid, since the command's output carries no expiry of its own.",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// Add retry_* schema fields: response-aware retries for rate-limited and
+	// transiently-failing requests, layered on top of the header/mTLS
+	// transport.
+	upstream.Schema["retry_max_attempts"] = &schema.Schema{
+		Type:        schema.TypeInt,
+		Optional:    true,
+		Default:     defaultRetryMaxAttempts,
+		Description: "Maximum number of attempts (including the first) for a request that receives a retryable response. Set to 1 to disable retries.",
+	}
+	upstream.Schema["retry_max_backoff"] = &schema.Schema{
+		Type:        schema.TypeInt,
+		Optional:    true,
+		Default:     int(defaultRetryMaxBackoff / time.Second),
+		Description: "Maximum backoff between retries, in seconds, whether derived from a Retry-After header or exponential backoff.",
+	}
+	upstream.Schema["retry_status_codes"] = &schema.Schema{
+		Type:        schema.TypeList,
+		Optional:    true,
+		Description: "HTTP status codes that trigger a retry. Defaults to 429, 502, 503, and 504.",
+		Elem:        &schema.Schema{Type: schema.TypeInt},
+	}
+	upstream.Schema["retry_non_idempotent"] = &schema.Schema{
+		Type:        schema.TypeBool,
+		Optional:    true,
+		Default:     false,
+		Description: "Also retry non-idempotent methods (POST, PATCH) that receive a retryable response, even without an Idempotency-Key echoed back by the server.",
+	}
+
 	// Wrap the original ConfigureFunc to capture headers and set up custom transport
 	originalConfigure := upstream.ConfigureFunc
 	upstream.ConfigureFunc = func(d *schema.ResourceData) (interface{}, error) {
-		// Parse extra headers from config or environment
-		headers := make(map[string]string)
+		// extra_headers_allow_reserved defaults to false, so d.Get always
+		// returns the effective value (explicit or default). GetOk would
+		// report ok=false for an explicit "false" too, indistinguishable
+		// from unset, and since allowReservedHeaders is a package-level
+		// global, that would leave a previous instance's "true" stuck.
+		AllowReservedHeaders(d.Get("extra_headers_allow_reserved").(bool))
 
-		if v, ok := d.GetOk("extra_headers"); ok {
-			for key, val := range v.(map[string]interface{}) {
-				headers[key] = val.(string)
-			}
+		multiHeaders, headerFiles, err := parseExtraHeaders(d)
+		if err != nil {
+			return nil, err
 		}
 
-		// Also check environment variable as JSON
-		if envHeaders := os.Getenv("RANCHER_EXTRA_HEADERS"); envHeaders != "" && len(headers) == 0 {
-			var envMap map[string]string
-			if err := json.Unmarshal([]byte(envHeaders), &envMap); err == nil {
-				headers = envMap
-			}
+		rules, err := parseHeaderRules(d)
+		if err != nil {
+			return nil, err
+		}
+
+		tokenProvider, err := parseTokenProvider(d)
+		if err != nil {
+			return nil, err
 		}
 
-		// Store headers for later use
-		if len(headers) > 0 {
-			SetExtraHeaders(headers)
-			// Modify default HTTP transport to include headers
-			configureHTTPTransport(headers, d)
+		retry := parseRetryConfig(d)
+
+		if err := SetExtraHeaders(multiHeaders); err != nil {
+			return nil, err
+		}
+		if err := SetExtraHeaderFiles(headerFiles); err != nil {
+			return nil, err
+		}
+		if err := SetExtraHeaderRules(rules); err != nil {
+			return nil, err
+		}
+		SetTokenProvider(tokenProvider)
+
+		// http.DefaultTransport/http.DefaultClient are process-global, and
+		// originalConfigure reads them to build the upstream rancher2
+		// client. Hold configureHTTPTransportMu from here through
+		// originalConfigure's return so a concurrently-configuring aliased
+		// provider instance can't observe or clobber this instance's
+		// transport mid-Configure.
+		configureHTTPTransportMu.Lock()
+		defer configureHTTPTransportMu.Unlock()
+
+		// Modify the default HTTP transport to include headers, mutual TLS
+		// (if configured), and response-aware retries.
+		if err := configureHTTPTransport(multiHeaders, headerFiles, rules, tokenProvider, retry, d); err != nil {
+			return nil, err
 		}
 
 		// Call original configure
@@ -99,55 +786,328 @@ func Provider() *schema.Provider {
 	return upstream
 }
 
-// configureHTTPTransport sets up the default HTTP client with custom headers
-func configureHTTPTransport(headers map[string]string, d *schema.ResourceData) {
+// parseExtraHeaders reads the extra_headers, extra_headers_multi, and
+// extra_headers_files schema fields (falling back to the
+// RANCHER_EXTRA_HEADERS/RANCHER_EXTRA_HEADERS_FILES environment variables
+// when the corresponding field is unset) into a combined header map and a
+// header-file map. It returns an error if a header key is set in more than
+// one of the three sources.
+func parseExtraHeaders(d *schema.ResourceData) (map[string][]string, map[string]string, error) {
+	headers := make(map[string]string)
+
+	if v, ok := d.GetOk("extra_headers"); ok {
+		for key, val := range v.(map[string]interface{}) {
+			headers[key] = val.(string)
+		}
+	}
+
+	// Also check environment variable as JSON
+	if envHeaders := os.Getenv("RANCHER_EXTRA_HEADERS"); envHeaders != "" && len(headers) == 0 {
+		var envMap map[string]string
+		if err := json.Unmarshal([]byte(envHeaders), &envMap); err == nil {
+			headers = envMap
+		}
+	}
+
+	// Parse file-backed extra headers from config or environment
+	headerFiles := make(map[string]string)
+
+	if v, ok := d.GetOk("extra_headers_files"); ok {
+		for key, val := range v.(map[string]interface{}) {
+			headerFiles[key] = val.(string)
+		}
+	}
+
+	if envHeaderFiles := os.Getenv("RANCHER_EXTRA_HEADERS_FILES"); envHeaderFiles != "" && len(headerFiles) == 0 {
+		var envMap map[string]string
+		if err := json.Unmarshal([]byte(envHeaderFiles), &envMap); err == nil {
+			headerFiles = envMap
+		}
+	}
+
+	for key := range headerFiles {
+		if _, ok := headers[key]; ok {
+			return nil, nil, fmt.Errorf("header %q is set in both extra_headers and extra_headers_files; a header may only come from one source", key)
+		}
+	}
+
+	// extra_headers is a simple map, so each key carries a single value;
+	// wrap it as headerTransport's map[string][]string expects.
+	multiHeaders := make(map[string][]string, len(headers))
+	for key, value := range headers {
+		multiHeaders[key] = []string{value}
+	}
+
+	// extra_headers_multi covers headers that need more than one value,
+	// which extra_headers (a TypeMap) can't represent.
+	if v, ok := d.GetOk("extra_headers_multi"); ok {
+		extraMulti := parseMultiHeaderBlocks(v.([]interface{}))
+		for key := range extraMulti {
+			if _, ok := headers[key]; ok {
+				return nil, nil, fmt.Errorf("header %q is set in both extra_headers and extra_headers_multi; a header may only come from one source", key)
+			}
+			if _, ok := headerFiles[key]; ok {
+				return nil, nil, fmt.Errorf("header %q is set in both extra_headers_multi and extra_headers_files; a header may only come from one source", key)
+			}
+		}
+		for key, values := range extraMulti {
+			multiHeaders[key] = values
+		}
+	}
+
+	return multiHeaders, headerFiles, nil
+}
+
+// parseMultiHeaderBlocks reads a list of {name, values} blocks (the shape
+// used by extra_headers_multi and a header rule's headers_multi) into a
+// map[string][]string.
+func parseMultiHeaderBlocks(raw []interface{}) map[string][]string {
+	result := make(map[string][]string, len(raw))
+	for _, item := range raw {
+		block := item.(map[string]interface{})
+		name := block["name"].(string)
+		for _, v := range block["values"].([]interface{}) {
+			result[name] = append(result[name], v.(string))
+		}
+	}
+	return result
+}
+
+// parseHeaderRules reads the extra_header_rules schema field into headerRule
+// values. It returns an error if a rule sets neither headers nor
+// headers_multi, or sets the same header key in both.
+func parseHeaderRules(d *schema.ResourceData) ([]headerRule, error) {
+	v, ok := d.GetOk("extra_header_rules")
+	if !ok {
+		return nil, nil
+	}
+
+	rawRules := v.([]interface{})
+	rules := make([]headerRule, 0, len(rawRules))
+	for i, rawRule := range rawRules {
+		ruleMap := rawRule.(map[string]interface{})
+
+		rule := headerRule{
+			pathPrefix: ruleMap["path_prefix"].(string),
+			headers:    make(map[string][]string),
+		}
+
+		for _, h := range ruleMap["hosts"].([]interface{}) {
+			rule.hosts = append(rule.hosts, h.(string))
+		}
+		for _, m := range ruleMap["methods"].([]interface{}) {
+			rule.methods = append(rule.methods, m.(string))
+		}
+
+		singleValued := make(map[string]struct{})
+		for key, value := range ruleMap["headers"].(map[string]interface{}) {
+			rule.headers[key] = []string{value.(string)}
+			singleValued[key] = struct{}{}
+		}
+
+		multi := parseMultiHeaderBlocks(ruleMap["headers_multi"].([]interface{}))
+		for key, values := range multi {
+			if _, ok := singleValued[key]; ok {
+				return nil, fmt.Errorf("extra_header_rules[%d]: header %q is set in both headers and headers_multi; a header may only come from one source", i, key)
+			}
+			rule.headers[key] = values
+		}
+
+		if len(rule.headers) == 0 {
+			return nil, fmt.Errorf("extra_header_rules[%d]: at least one of headers or headers_multi is required", i)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// cloneDefaultTransport returns a copy of http.DefaultTransport's
+// *http.Transport with its tuned defaults (Proxy, HTTP/2, timeouts,
+// connection pooling) intact, for configureHTTPTransport to layer TLS
+// settings onto before replacing http.DefaultTransport wholesale. It falls
+// back to hand-built equivalents of those defaults if http.DefaultTransport
+// has already been replaced with something other than an *http.Transport
+// (e.g. by a re-entrant Configure call).
+func cloneDefaultTransport() *http.Transport {
+	if dt, ok := http.DefaultTransport.(*http.Transport); ok {
+		return dt.Clone()
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          defaultMaxIdleConns,
+		IdleConnTimeout:       defaultIdleConnTimeout,
+		TLSHandshakeTimeout:   defaultTLSHandshakeTimeout,
+		ExpectContinueTimeout: defaultExpectContinueTimeout,
+		DialContext: (&net.Dialer{
+			Timeout: defaultDialTimeout,
+		}).DialContext,
+	}
+}
+
+// configureHTTPTransport sets up the default HTTP client with custom
+// headers, mutual TLS (if configured), and response-aware retries.
+func configureHTTPTransport(headers map[string][]string, headerFiles map[string]string, rules []headerRule, tokenProvider *tokenProviderConfig, retry retryConfig, d *schema.ResourceData) error {
 	// Get insecure setting
 	insecure := false
 	if v, ok := d.GetOk("insecure"); ok {
 		insecure = v.(bool)
 	}
 
-	// Create base transport
-	baseTransport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: insecure,
-		},
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: insecure,
 	}
 
-	// Handle CA certs if provided
-	if v, ok := d.GetOk("ca_certs"); ok && v.(string) != "" {
-		// The rancher2 provider handles CA certs internally
-		// We just need to set up the header transport
+	cert, err := loadClientCertificate(d)
+	if err != nil {
+		return err
+	}
+	if cert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*cert}
+	}
+
+	caPool, err := loadCACertPool(d)
+	if err != nil {
+		return err
+	}
+	if caPool != nil {
+		tlsConfig.RootCAs = caPool
 	}
 
+	// Create base transport. http.DefaultTransport is about to be replaced
+	// wholesale, so clone it first and restore its tuned defaults -
+	// including Proxy (HTTP_PROXY/HTTPS_PROXY/NO_PROXY support, which this
+	// shim exists to sit in front of) - rather than leaving a bare
+	// zero-value http.Transport that silently drops them.
+	baseTransport := cloneDefaultTransport()
+	baseTransport.TLSClientConfig = tlsConfig
+
 	// Create header transport wrapper
-	transport := &headerTransport{
-		base:    baseTransport,
-		headers: headers,
+	headerRT := &headerTransport{
+		base:          baseTransport,
+		headers:       headers,
+		headerFiles:   headerFiles,
+		rules:         rules,
+		tokenProvider: tokenProvider,
+	}
+
+	// Layer response-aware retries on top, unless the user disabled them by
+	// setting retry_max_attempts to 1.
+	var transport http.RoundTripper = headerRT
+	if retry.maxAttempts > 1 {
+		transport = &retryTransport{
+			base:               headerRT,
+			maxAttempts:        retry.maxAttempts,
+			maxBackoff:         retry.maxBackoff,
+			statusCodes:        retry.statusCodes,
+			retryNonIdempotent: retry.retryNonIdempotent,
+		}
 	}
 
 	// Set as default transport
 	http.DefaultTransport = transport
 	http.DefaultClient = &http.Client{Transport: transport}
+	return nil
+}
+
+// loadClientCertificate builds a client certificate for mutual TLS from
+// either the client_cert_pem/client_key_pem or client_cert_file/client_key_file
+// schema fields. It returns (nil, nil) if neither is configured.
+func loadClientCertificate(d *schema.ResourceData) (*tls.Certificate, error) {
+	certPEM := d.Get("client_cert_pem").(string)
+	keyPEM := d.Get("client_key_pem").(string)
+	certFile := d.Get("client_cert_file").(string)
+	keyFile := d.Get("client_key_file").(string)
+
+	switch {
+	case (certPEM != "" || keyPEM != "") && (certFile != "" || keyFile != ""):
+		return nil, fmt.Errorf("client_cert_pem/client_key_pem and client_cert_file/client_key_file are mutually exclusive; set only one form")
+	case certPEM != "" || keyPEM != "":
+		if certPEM == "" || keyPEM == "" {
+			return nil, fmt.Errorf("client_cert_pem and client_key_pem must both be set")
+		}
+		cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate from client_cert_pem/client_key_pem: %w", err)
+		}
+		return &cert, nil
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("client_cert_file and client_key_file must both be set")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate from client_cert_file/client_key_file: %w", err)
+		}
+		return &cert, nil
+	default:
+		return nil, nil
+	}
+}
+
+// loadCACertPool builds a CertPool from either the ca_cert_pem or
+// ca_cert_file schema field. It returns (nil, nil) if neither is configured.
+func loadCACertPool(d *schema.ResourceData) (*x509.CertPool, error) {
+	caPEM := d.Get("ca_cert_pem").(string)
+	caFile := d.Get("ca_cert_file").(string)
+
+	if caPEM == "" && caFile == "" {
+		return nil, nil
+	}
+	if caPEM != "" && caFile != "" {
+		return nil, fmt.Errorf("ca_cert_pem and ca_cert_file are mutually exclusive; set only one form")
+	}
+
+	if caFile != "" {
+		contents, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ca_cert_file: %w", err)
+		}
+		caPEM = string(contents)
+	}
+
+	// Seed from the system trust store so ca_cert_pem/ca_cert_file adds a
+	// CA rather than replacing tls.Config.RootCAs wholesale, which would
+	// otherwise silently break verification of every endpoint whose cert
+	// chains through a public CA.
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM([]byte(caPEM)) {
+		return nil, fmt.Errorf("no certificates found in ca_cert_pem/ca_cert_file")
+	}
+	return pool, nil
 }
 
 // GetTransportWithHeaders returns an http.RoundTripper that adds the configured headers
 func GetTransportWithHeaders(base http.RoundTripper) http.RoundTripper {
 	headers := GetExtraHeaders()
-	if len(headers) == 0 {
+	headerFiles := GetExtraHeaderFiles()
+	rules := GetExtraHeaderRules()
+	tokenProvider := GetTokenProvider()
+	if len(headers) == 0 && len(headerFiles) == 0 && len(rules) == 0 && tokenProvider == nil {
 		return base
 	}
 	return &headerTransport{
-		base:    base,
-		headers: headers,
+		base:          base,
+		headers:       headers,
+		headerFiles:   headerFiles,
+		rules:         rules,
+		tokenProvider: tokenProvider,
 	}
 }
 
-// ParseHeadersString parses a header string in format "Key1: Value1, Key2: Value2"
-func ParseHeadersString(s string) map[string]string {
+// ParseHeadersString parses a header string in format "Key1: Value1, Key2: Value2".
+// It returns an error, without returning the partially parsed headers, if the
+// string sets a reserved header and reserved headers have not been allowed
+// via AllowReservedHeaders.
+func ParseHeadersString(s string) (map[string]string, error) {
 	headers := make(map[string]string)
 	if s == "" {
-		return headers
+		return headers, nil
 	}
 
 	pairs := strings.Split(s, ",")
@@ -161,5 +1121,9 @@ func ParseHeadersString(s string) map[string]string {
 			}
 		}
 	}
-	return headers
+
+	if err := checkReservedHeaders(headers); err != nil {
+		return nil, err
+	}
+	return headers, nil
 }
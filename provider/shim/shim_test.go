@@ -1,11 +1,247 @@
 package shim
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
 )
 
+// tokenProviderTestSchema is the token_provider field of shim's provider
+// schema, reproduced here so parseTokenProvider can be exercised against a
+// *schema.ResourceData without constructing the full provider.
+var tokenProviderTestSchema = map[string]*schema.Schema{
+	"token_provider": {
+		Type:     schema.TypeList,
+		Optional: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"type":                 {Type: schema.TypeString, Required: true},
+				"header":               {Type: schema.TypeString, Optional: true},
+				"override":             {Type: schema.TypeBool, Optional: true, Default: true},
+				"refresh_skew_seconds": {Type: schema.TypeInt, Optional: true, Default: 60},
+				"cf_access": {
+					Type:     schema.TypeList,
+					Optional: true,
+					MaxItems: 1,
+					Elem: &schema.Resource{
+						Schema: map[string]*schema.Schema{
+							"app_url": {Type: schema.TypeString, Required: true},
+						},
+					},
+				},
+				"oidc_client_credentials": {
+					Type:     schema.TypeList,
+					Optional: true,
+					MaxItems: 1,
+					Elem: &schema.Resource{
+						Schema: map[string]*schema.Schema{
+							"token_url":     {Type: schema.TypeString, Required: true},
+							"client_id":     {Type: schema.TypeString, Required: true},
+							"client_secret": {Type: schema.TypeString, Required: true},
+							"scope":         {Type: schema.TypeString, Optional: true},
+						},
+					},
+				},
+				"exec": {
+					Type:     schema.TypeList,
+					Optional: true,
+					MaxItems: 1,
+					Elem: &schema.Resource{
+						Schema: map[string]*schema.Schema{
+							"command":     {Type: schema.TypeString, Required: true},
+							"args":        {Type: schema.TypeList, Optional: true, Elem: &schema.Schema{Type: schema.TypeString}},
+							"ttl_seconds": {Type: schema.TypeInt, Optional: true, Default: int(defaultTokenTTL / time.Second)},
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
+func tokenProviderResourceData(t *testing.T, raw map[string]interface{}) *schema.ResourceData {
+	t.Helper()
+	return schema.TestResourceDataRaw(t, tokenProviderTestSchema, raw)
+}
+
+// fakeTokenSource is a tokenSource test double that counts how many times
+// Token is called, so callers can assert on caching behavior.
+type fakeTokenSource struct {
+	mu     sync.Mutex
+	calls  int
+	value  string
+	expiry time.Time
+	err    error
+}
+
+func (f *fakeTokenSource) Token(ctx context.Context) (string, time.Time, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return "", time.Time{}, f.err
+	}
+	return f.value, f.expiry, nil
+}
+
+func (f *fakeTokenSource) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// fakeJWT builds a minimally valid, unsigned JWT with the given exp claim,
+// since jwtExpiry only decodes the payload and doesn't verify signatures.
+func fakeJWT(t *testing.T, exp int64) string {
+	t.Helper()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	payload, err := json.Marshal(map[string]int64{"exp": exp})
+	if err != nil {
+		t.Fatalf("marshaling JWT payload: %v", err)
+	}
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+}
+
+// mtlsSchema is the slice of shim's mTLS-related provider schema fields,
+// reproduced here so loadClientCertificate/loadCACertPool can be exercised
+// against a *schema.ResourceData without constructing the full provider.
+var mtlsSchema = map[string]*schema.Schema{
+	"client_cert_pem":  {Type: schema.TypeString, Optional: true},
+	"client_key_pem":   {Type: schema.TypeString, Optional: true},
+	"client_cert_file": {Type: schema.TypeString, Optional: true},
+	"client_key_file":  {Type: schema.TypeString, Optional: true},
+	"ca_cert_pem":      {Type: schema.TypeString, Optional: true},
+	"ca_cert_file":     {Type: schema.TypeString, Optional: true},
+	"insecure":         {Type: schema.TypeBool, Optional: true},
+}
+
+func mtlsResourceData(t *testing.T, raw map[string]interface{}) *schema.ResourceData {
+	t.Helper()
+	return schema.TestResourceDataRaw(t, mtlsSchema, raw)
+}
+
+// multiHeaderBlockSchema is the {name, values} block shape shared by
+// extra_headers_multi and a header rule's headers_multi, reproduced here so
+// headerResourceData can build both.
+var multiHeaderBlockSchema = &schema.Resource{
+	Schema: map[string]*schema.Schema{
+		"name":   {Type: schema.TypeString, Required: true},
+		"values": {Type: schema.TypeList, Required: true, Elem: &schema.Schema{Type: schema.TypeString}},
+	},
+}
+
+// headerSchema is the slice of shim's header-related provider schema fields,
+// reproduced here so parseExtraHeaders/parseHeaderRules can be exercised
+// against a *schema.ResourceData without constructing the full provider.
+var headerSchema = map[string]*schema.Schema{
+	"extra_headers": {
+		Type:     schema.TypeMap,
+		Optional: true,
+		Elem:     &schema.Schema{Type: schema.TypeString},
+	},
+	"extra_headers_multi": {
+		Type:     schema.TypeList,
+		Optional: true,
+		Elem:     multiHeaderBlockSchema,
+	},
+	"extra_headers_files": {
+		Type:     schema.TypeMap,
+		Optional: true,
+		Elem:     &schema.Schema{Type: schema.TypeString},
+	},
+	"extra_header_rules": {
+		Type:     schema.TypeList,
+		Optional: true,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"hosts":       {Type: schema.TypeList, Optional: true, Elem: &schema.Schema{Type: schema.TypeString}},
+				"path_prefix": {Type: schema.TypeString, Optional: true},
+				"methods":     {Type: schema.TypeList, Optional: true, Elem: &schema.Schema{Type: schema.TypeString}},
+				"headers": {
+					Type:     schema.TypeMap,
+					Optional: true,
+					Elem:     &schema.Schema{Type: schema.TypeString},
+				},
+				"headers_multi": {
+					Type:     schema.TypeList,
+					Optional: true,
+					Elem:     multiHeaderBlockSchema,
+				},
+			},
+		},
+	},
+}
+
+func headerResourceData(t *testing.T, raw map[string]interface{}) *schema.ResourceData {
+	t.Helper()
+	return schema.TestResourceDataRaw(t, headerSchema, raw)
+}
+
+// generateSelfSignedCertPEM returns a freshly generated, self-signed
+// EC client certificate and key, PEM-encoded.
+func generateSelfSignedCertPEM(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+	return generateSelfSignedCertPEMWithCN(t, "shim-test-client")
+}
+
+// generateSelfSignedCertPEMWithCN is generateSelfSignedCertPEM with a
+// caller-chosen CommonName, so tests that need to tell two generated
+// certificates apart (e.g. by inspecting an installed tls.Config) can.
+func generateSelfSignedCertPEMWithCN(t *testing.T, cn string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	keyBytes, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("marshaling key: %v", err)
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+
+	return certPEM, keyPEM
+}
+
 func TestHeaderTransport(t *testing.T) {
 	// Create a test server that captures headers
 	var capturedHeaders http.Header
@@ -16,10 +252,10 @@ func TestHeaderTransport(t *testing.T) {
 	defer server.Close()
 
 	// Create header transport with custom headers
-	headers := map[string]string{
-		"X-Custom-Header":  "test-value",
-		"X-Proxy-Auth":     "bearer-token-123",
-		"X-Request-Source": "pulumi-test",
+	headers := map[string][]string{
+		"X-Custom-Header":  {"test-value"},
+		"X-Proxy-Auth":     {"bearer-token-123"},
+		"X-Request-Source": {"pulumi-test"},
 	}
 
 	transport := &headerTransport{
@@ -38,10 +274,10 @@ func TestHeaderTransport(t *testing.T) {
 	defer resp.Body.Close()
 
 	// Verify headers were added
-	for key, expectedValue := range headers {
+	for key, expectedValues := range headers {
 		actualValue := capturedHeaders.Get(key)
-		if actualValue != expectedValue {
-			t.Errorf("Header %s: expected %q, got %q", key, expectedValue, actualValue)
+		if actualValue != expectedValues[0] {
+			t.Errorf("Header %s: expected %q, got %q", key, expectedValues[0], actualValue)
 		}
 	}
 
@@ -51,12 +287,14 @@ func TestHeaderTransport(t *testing.T) {
 
 func TestSetAndGetExtraHeaders(t *testing.T) {
 	// Test setting and getting headers
-	headers := map[string]string{
-		"X-Test": "value1",
-		"X-Auth": "value2",
+	headers := map[string][]string{
+		"X-Test": {"value1"},
+		"X-Auth": {"value2"},
 	}
 
-	SetExtraHeaders(headers)
+	if err := SetExtraHeaders(headers); err != nil {
+		t.Fatalf("SetExtraHeaders: %v", err)
+	}
 	retrieved := GetExtraHeaders()
 
 	if len(retrieved) != len(headers) {
@@ -64,8 +302,8 @@ func TestSetAndGetExtraHeaders(t *testing.T) {
 	}
 
 	for key, expected := range headers {
-		if retrieved[key] != expected {
-			t.Errorf("Header %s: expected %q, got %q", key, expected, retrieved[key])
+		if len(retrieved[key]) != 1 || retrieved[key][0] != expected[0] {
+			t.Errorf("Header %s: expected %v, got %v", key, expected, retrieved[key])
 		}
 	}
 }
@@ -79,10 +317,6 @@ func TestParseHeadersString(t *testing.T) {
 			input:    "X-Header1: value1, X-Header2: value2",
 			expected: map[string]string{"X-Header1": "value1", "X-Header2": "value2"},
 		},
-		{
-			input:    "Authorization: Bearer token123",
-			expected: map[string]string{"Authorization": "Bearer token123"},
-		},
 		{
 			input:    "",
 			expected: map[string]string{},
@@ -94,7 +328,11 @@ func TestParseHeadersString(t *testing.T) {
 	}
 
 	for _, tc := range tests {
-		result := ParseHeadersString(tc.input)
+		result, err := ParseHeadersString(tc.input)
+		if err != nil {
+			t.Errorf("Input %q: unexpected error: %v", tc.input, err)
+			continue
+		}
 		if len(result) != len(tc.expected) {
 			t.Errorf("Input %q: expected %d headers, got %d", tc.input, len(tc.expected), len(result))
 			continue
@@ -107,9 +345,43 @@ func TestParseHeadersString(t *testing.T) {
 	}
 }
 
+func TestParseHeadersStringRejectsReserved(t *testing.T) {
+	defer AllowReservedHeaders(false)
+
+	if _, err := ParseHeadersString("Authorization: Bearer token123"); err == nil {
+		t.Error("expected error setting reserved header Authorization, got nil")
+	}
+
+	AllowReservedHeaders(true)
+	result, err := ParseHeadersString("Authorization: Bearer token123")
+	if err != nil {
+		t.Fatalf("unexpected error with reserved headers allowed: %v", err)
+	}
+	if result["Authorization"] != "Bearer token123" {
+		t.Errorf("expected Authorization to be set, got %q", result["Authorization"])
+	}
+}
+
+func TestSetExtraHeadersRejectsReserved(t *testing.T) {
+	defer AllowReservedHeaders(false)
+
+	err := SetExtraHeaders(map[string][]string{"Cookie": {"session=1"}})
+	if err == nil {
+		t.Fatal("expected error setting reserved header Cookie, got nil")
+	}
+
+	AllowReservedHeaders(true)
+	if err := SetExtraHeaders(map[string][]string{"Cookie": {"session=1"}}); err != nil {
+		t.Fatalf("unexpected error with reserved headers allowed: %v", err)
+	}
+}
+
 func TestGetTransportWithHeaders(t *testing.T) {
 	// Clear any existing headers
 	SetExtraHeaders(nil)
+	SetExtraHeaderFiles(nil)
+	SetExtraHeaderRules(nil)
+	SetTokenProvider(nil)
 
 	// Without headers, should return base transport
 	base := http.DefaultTransport
@@ -119,7 +391,7 @@ func TestGetTransportWithHeaders(t *testing.T) {
 	}
 
 	// With headers, should return wrapped transport
-	SetExtraHeaders(map[string]string{"X-Test": "value"})
+	SetExtraHeaders(map[string][]string{"X-Test": {"value"}})
 	result = GetTransportWithHeaders(base)
 	if result == base {
 		t.Error("Expected wrapped transport when headers are set")
@@ -131,6 +403,129 @@ func TestGetTransportWithHeaders(t *testing.T) {
 	}
 }
 
+func TestHeaderTransportFileBackedHeader(t *testing.T) {
+	var capturedHeaders http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capturedHeaders = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("initial-token\n"), 0o600); err != nil {
+		t.Fatalf("writing header file: %v", err)
+	}
+
+	transport := &headerTransport{
+		base:        http.DefaultTransport,
+		headerFiles: map[string]string{"X-Proxy-Token": path},
+	}
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if got := capturedHeaders.Get("X-Proxy-Token"); got != "initial-token" {
+		t.Errorf("expected initial-token, got %q", got)
+	}
+
+	// Rotate the secret on disk, forcing a distinctly later mtime (rather
+	// than relying on wall-clock timing, which can land within the same
+	// tick as the initial write on a coarse-resolution filesystem): the new
+	// value should be visible on the very next request, without waiting
+	// out fileHeaderCacheTTL.
+	initialInfo, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("rotated-token\n"), 0o600); err != nil {
+		t.Fatalf("rewriting header file: %v", err)
+	}
+	rotatedModTime := initialInfo.ModTime().Add(time.Second)
+	if err := os.Chtimes(path, rotatedModTime, rotatedModTime); err != nil {
+		t.Fatalf("bumping mtime: %v", err)
+	}
+
+	resp, err = client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := capturedHeaders.Get("X-Proxy-Token"); got != "rotated-token" {
+		t.Errorf("expected rotated-token as soon as mtime changed, got %q", got)
+	}
+
+	// Simulate a sidecar that rewrites a file's contents without bumping
+	// its mtime (or a filesystem with coarse mtime resolution): the cached
+	// value should still be served until fileHeaderCacheTTL's fallback
+	// elapses.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	unchangedModTime := info.ModTime()
+	if err := os.WriteFile(path, []byte("stealth-rotation-token\n"), 0o600); err != nil {
+		t.Fatalf("rewriting header file: %v", err)
+	}
+	if err := os.Chtimes(path, unchangedModTime, unchangedModTime); err != nil {
+		t.Fatalf("resetting mtime: %v", err)
+	}
+
+	resp, err = client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := capturedHeaders.Get("X-Proxy-Token"); got != "rotated-token" {
+		t.Errorf("expected cached rotated-token while mtime is unchanged, got %q", got)
+	}
+
+	time.Sleep(fileHeaderCacheTTL + 100*time.Millisecond)
+
+	resp, err = client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := capturedHeaders.Get("X-Proxy-Token"); got != "stealth-rotation-token" {
+		t.Errorf("expected stealth-rotation-token after TTL fallback elapsed, got %q", got)
+	}
+}
+
+func TestFileHeaderCacheServesCacheOnTransientStatFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("initial-token\n"), 0o600); err != nil {
+		t.Fatalf("writing header file: %v", err)
+	}
+
+	var cache fileHeaderCache
+	value, err := cache.read(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if value != "initial-token" {
+		t.Fatalf("expected initial-token, got %q", value)
+	}
+
+	// Simulate a sidecar that briefly unlinks the file while rewriting it:
+	// os.Stat fails, but the cached value is still within its TTL and
+	// should be served rather than surfacing the stat error.
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("removing header file: %v", err)
+	}
+
+	value, err = cache.read(path)
+	if err != nil {
+		t.Errorf("expected cached value to be served despite a transient stat failure, got error: %v", err)
+	}
+	if value != "initial-token" {
+		t.Errorf("expected cached initial-token, got %q", value)
+	}
+}
+
 func TestHeaderTransportPreservesOriginalHeaders(t *testing.T) {
 	// Create a test server that captures headers
 	var capturedHeaders http.Header
@@ -143,7 +538,7 @@ func TestHeaderTransportPreservesOriginalHeaders(t *testing.T) {
 	// Create header transport
 	transport := &headerTransport{
 		base:    http.DefaultTransport,
-		headers: map[string]string{"X-Custom": "custom-value"},
+		headers: map[string][]string{"X-Custom": {"custom-value"}},
 	}
 
 	client := &http.Client{Transport: transport}
@@ -172,3 +567,848 @@ func TestHeaderTransportPreservesOriginalHeaders(t *testing.T) {
 		t.Error("Custom header X-Custom was not added")
 	}
 }
+
+func TestHeaderTransportMultiValueHeader(t *testing.T) {
+	var capturedHeaders http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capturedHeaders = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	transport := &headerTransport{
+		base: http.DefaultTransport,
+		headers: map[string][]string{
+			"X-Forwarded-For": {"10.0.0.1", "10.0.0.2"},
+		},
+	}
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	got := capturedHeaders.Values("X-Forwarded-For")
+	want := []string{"10.0.0.1", "10.0.0.2"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected X-Forwarded-For %v, got %v", want, got)
+	}
+}
+
+func TestHeaderTransportPerHostRule(t *testing.T) {
+	var capturedHeaders http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capturedHeaders = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("parsing server URL: %v", err)
+	}
+
+	transport := &headerTransport{
+		base:    http.DefaultTransport,
+		headers: map[string][]string{"X-Global": {"global-value"}},
+		rules: []headerRule{
+			{
+				hosts:   []string{"other.invalid"},
+				headers: map[string][]string{"X-Other": {"should-not-appear"}},
+			},
+			{
+				hosts:   []string{serverURL.Host},
+				headers: map[string][]string{"X-Rancher-Proxy": {"cluster-token"}},
+			},
+		},
+	}
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if capturedHeaders.Get("X-Global") != "global-value" {
+		t.Error("expected global header to still be set")
+	}
+	if capturedHeaders.Get("X-Other") != "" {
+		t.Error("rule for a different host must not apply")
+	}
+	if capturedHeaders.Get("X-Rancher-Proxy") != "cluster-token" {
+		t.Error("expected matching host rule to add X-Rancher-Proxy")
+	}
+}
+
+func TestLoadClientCertificateFromPEM(t *testing.T) {
+	certPEM, keyPEM := generateSelfSignedCertPEM(t)
+
+	d := mtlsResourceData(t, map[string]interface{}{
+		"client_cert_pem": string(certPEM),
+		"client_key_pem":  string(keyPEM),
+	})
+
+	cert, err := loadClientCertificate(d)
+	if err != nil {
+		t.Fatalf("loadClientCertificate: %v", err)
+	}
+	if cert == nil {
+		t.Fatal("expected a certificate, got nil")
+	}
+}
+
+func TestLoadClientCertificateFromFiles(t *testing.T) {
+	certPEM, keyPEM := generateSelfSignedCertPEM(t)
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatalf("writing cert file: %v", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatalf("writing key file: %v", err)
+	}
+
+	d := mtlsResourceData(t, map[string]interface{}{
+		"client_cert_file": certFile,
+		"client_key_file":  keyFile,
+	})
+
+	cert, err := loadClientCertificate(d)
+	if err != nil {
+		t.Fatalf("loadClientCertificate: %v", err)
+	}
+	if cert == nil {
+		t.Fatal("expected a certificate, got nil")
+	}
+}
+
+func TestLoadClientCertificateRejectsPartialConfig(t *testing.T) {
+	certPEM, _ := generateSelfSignedCertPEM(t)
+
+	d := mtlsResourceData(t, map[string]interface{}{
+		"client_cert_pem": string(certPEM),
+	})
+
+	if _, err := loadClientCertificate(d); err == nil {
+		t.Error("expected error when client_key_pem is missing")
+	}
+}
+
+func TestLoadClientCertificateRejectsBothPEMAndFile(t *testing.T) {
+	certPEM, keyPEM := generateSelfSignedCertPEM(t)
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatalf("writing cert file: %v", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatalf("writing key file: %v", err)
+	}
+
+	d := mtlsResourceData(t, map[string]interface{}{
+		"client_cert_pem":  string(certPEM),
+		"client_key_pem":   string(keyPEM),
+		"client_cert_file": certFile,
+		"client_key_file":  keyFile,
+	})
+
+	if _, err := loadClientCertificate(d); err == nil {
+		t.Error("expected error when both PEM and file forms of the client certificate are set")
+	}
+}
+
+func TestLoadCACertPoolFromPEM(t *testing.T) {
+	caPEM, _ := generateSelfSignedCertPEM(t)
+
+	d := mtlsResourceData(t, map[string]interface{}{
+		"ca_cert_pem": string(caPEM),
+	})
+
+	pool, err := loadCACertPool(d)
+	if err != nil {
+		t.Fatalf("loadCACertPool: %v", err)
+	}
+	if pool == nil {
+		t.Fatal("expected a cert pool, got nil")
+	}
+}
+
+func TestLoadCACertPoolSupplementsSystemRoots(t *testing.T) {
+	const systemBundlePath = "/etc/ssl/certs/ca-certificates.crt"
+	bundle, err := os.ReadFile(systemBundlePath)
+	if err != nil {
+		t.Skipf("no system CA bundle at %s to test against in this environment", systemBundlePath)
+	}
+	block, _ := pem.Decode(bundle)
+	if block == nil {
+		t.Skip("could not find a certificate in the system CA bundle")
+	}
+	sysRoot, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parsing system root certificate: %v", err)
+	}
+
+	// ca_cert_pem should add its CA in addition to the system trust store,
+	// not replace it wholesale: a cert that only chains to a system root
+	// (not to the CA supplied here) must still verify.
+	extraCAPEM, _ := generateSelfSignedCertPEM(t)
+	d := mtlsResourceData(t, map[string]interface{}{"ca_cert_pem": string(extraCAPEM)})
+
+	pool, err := loadCACertPool(d)
+	if err != nil {
+		t.Fatalf("loadCACertPool: %v", err)
+	}
+
+	if _, err := sysRoot.Verify(x509.VerifyOptions{Roots: pool}); err != nil {
+		t.Errorf("expected a system-trusted root to still verify with ca_cert_pem also set, got: %v", err)
+	}
+}
+
+func TestLoadCACertPoolRejectsBothPEMAndFile(t *testing.T) {
+	caPEM, _ := generateSelfSignedCertPEM(t)
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatalf("writing ca file: %v", err)
+	}
+
+	d := mtlsResourceData(t, map[string]interface{}{
+		"ca_cert_pem":  string(caPEM),
+		"ca_cert_file": caFile,
+	})
+
+	if _, err := loadCACertPool(d); err == nil {
+		t.Error("expected error when both ca_cert_pem and ca_cert_file are set")
+	}
+}
+
+func TestMutualTLSPresentsClientCertificate(t *testing.T) {
+	certPEM, keyPEM := generateSelfSignedCertPEM(t)
+
+	var sawClientCert bool
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sawClientCert = len(r.TLS.PeerCertificates) > 0
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	d := mtlsResourceData(t, map[string]interface{}{
+		"client_cert_pem": string(certPEM),
+		"client_key_pem":  string(keyPEM),
+	})
+
+	cert, err := loadClientCertificate(d)
+	if err != nil {
+		t.Fatalf("loadClientCertificate: %v", err)
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			Certificates:       []tls.Certificate{*cert},
+			InsecureSkipVerify: true,
+		},
+	}
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if !sawClientCert {
+		t.Error("expected server to see the presented client certificate")
+	}
+}
+
+func TestJWTExpiry(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	got, err := jwtExpiry(fakeJWT(t, exp))
+	if err != nil {
+		t.Fatalf("jwtExpiry: %v", err)
+	}
+	if got.Unix() != exp {
+		t.Errorf("expected expiry %d, got %d", exp, got.Unix())
+	}
+
+	if _, err := jwtExpiry("not-a-jwt"); err == nil {
+		t.Error("expected error for a malformed token, got nil")
+	}
+}
+
+func TestCfAccessTokenSource(t *testing.T) {
+	token := fakeJWT(t, time.Now().Add(time.Hour).Unix())
+	var gotName string
+	var gotArgs []string
+	source := &cfAccessTokenSource{
+		appURL: "https://app.example.com",
+		run: func(ctx context.Context, name string, args []string) (string, error) {
+			gotName, gotArgs = name, args
+			return token, nil
+		},
+	}
+
+	value, expiry, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if value != token {
+		t.Errorf("expected token %q, got %q", token, value)
+	}
+	if expiry.IsZero() {
+		t.Error("expected a non-zero expiry decoded from the JWT")
+	}
+	if gotName != "cloudflared" {
+		t.Errorf("expected cloudflared to be invoked, got %q", gotName)
+	}
+	wantArgs := []string{"access", "token", "--app=https://app.example.com"}
+	if len(gotArgs) != len(wantArgs) || gotArgs[2] != wantArgs[2] {
+		t.Errorf("expected args %v, got %v", wantArgs, gotArgs)
+	}
+}
+
+func TestExecTokenSource(t *testing.T) {
+	source := &execTokenSource{
+		command: "token-helper",
+		args:    []string{"--foo"},
+		ttl:     time.Minute,
+		run: func(ctx context.Context, name string, args []string) (string, error) {
+			return "exec-token-value", nil
+		},
+	}
+
+	before := time.Now()
+	value, expiry, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if value != "exec-token-value" {
+		t.Errorf("expected exec-token-value, got %q", value)
+	}
+	if expiry.Before(before.Add(time.Minute - time.Second)) {
+		t.Errorf("expected expiry roughly ttl from now, got %v", expiry)
+	}
+}
+
+func TestOIDCClientCredentialsTokenSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parsing form: %v", err)
+		}
+		if got := r.PostForm.Get("grant_type"); got != "client_credentials" {
+			t.Errorf("expected grant_type client_credentials, got %q", got)
+		}
+		if got := r.PostForm.Get("client_id"); got != "my-client" {
+			t.Errorf("expected client_id my-client, got %q", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"abc123","expires_in":120,"token_type":"Bearer"}`))
+	}))
+	defer server.Close()
+
+	source := newOIDCClientCredentialsTokenSource(server.URL, "my-client", "my-secret", "")
+
+	before := time.Now()
+	value, expiry, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if value != "Bearer abc123" {
+		t.Errorf("expected %q, got %q", "Bearer abc123", value)
+	}
+	if expiry.Before(before.Add(119 * time.Second)) {
+		t.Errorf("expected expiry roughly 120s from now, got %v", expiry)
+	}
+}
+
+func TestHeaderTransportTokenProviderCachesUntilSkew(t *testing.T) {
+	var capturedHeaders http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capturedHeaders = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	source := &fakeTokenSource{value: "token-1", expiry: time.Now().Add(time.Hour)}
+	transport := &headerTransport{
+		base:          http.DefaultTransport,
+		tokenProvider: &tokenProviderConfig{source: source, header: "Authorization", override: true, skew: time.Minute},
+	}
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	if got := capturedHeaders.Get("Authorization"); got != "token-1" {
+		t.Errorf("expected Authorization token-1, got %q", got)
+	}
+	if source.callCount() != 1 {
+		t.Errorf("expected the token to be fetched once and cached, got %d calls", source.callCount())
+	}
+}
+
+func TestHeaderTransportTokenProviderOverrideFalse(t *testing.T) {
+	var capturedHeaders http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capturedHeaders = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	source := &fakeTokenSource{value: "dynamic-token", expiry: time.Now().Add(time.Hour)}
+	transport := &headerTransport{
+		base:          http.DefaultTransport,
+		headers:       map[string][]string{"Authorization": {"static-token"}},
+		tokenProvider: &tokenProviderConfig{source: source, header: "Authorization", override: false, skew: time.Minute},
+	}
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if got := capturedHeaders.Get("Authorization"); got != "static-token" {
+		t.Errorf("expected static-token to win when override is false, got %q", got)
+	}
+	if source.callCount() != 0 {
+		t.Error("expected the token provider not to be consulted when a static value already wins")
+	}
+}
+
+func TestParseTokenProviderCfAccess(t *testing.T) {
+	d := tokenProviderResourceData(t, map[string]interface{}{
+		"token_provider": []interface{}{
+			map[string]interface{}{
+				"type": "cf_access",
+				"cf_access": []interface{}{
+					map[string]interface{}{"app_url": "https://app.example.com"},
+				},
+			},
+		},
+	})
+
+	cfg, err := parseTokenProvider(d)
+	if err != nil {
+		t.Fatalf("parseTokenProvider: %v", err)
+	}
+	if cfg == nil {
+		t.Fatal("expected a tokenProviderConfig, got nil")
+	}
+	if cfg.header != "Cf-Access-Token" {
+		t.Errorf("expected header Cf-Access-Token, got %q", cfg.header)
+	}
+	if _, ok := cfg.source.(*cfAccessTokenSource); !ok {
+		t.Errorf("expected a *cfAccessTokenSource, got %T", cfg.source)
+	}
+	if !cfg.override {
+		t.Error("expected override to default to true")
+	}
+}
+
+func TestParseTokenProviderMissingSubBlock(t *testing.T) {
+	d := tokenProviderResourceData(t, map[string]interface{}{
+		"token_provider": []interface{}{
+			map[string]interface{}{"type": "cf_access"},
+		},
+	})
+
+	if _, err := parseTokenProvider(d); err == nil {
+		t.Error("expected an error when cf_access is not configured")
+	}
+}
+
+func TestParseTokenProviderUnsupportedType(t *testing.T) {
+	d := tokenProviderResourceData(t, map[string]interface{}{
+		"token_provider": []interface{}{
+			map[string]interface{}{"type": "bogus"},
+		},
+	})
+
+	if _, err := parseTokenProvider(d); err == nil {
+		t.Error("expected an error for an unsupported token_provider.type")
+	}
+}
+
+func TestRetryTransportRetriesOnRetryAfter(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&attempts, 1)
+		if n == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	transport := &retryTransport{
+		base:        http.DefaultTransport,
+		maxAttempts: defaultRetryMaxAttempts,
+		maxBackoff:  defaultRetryMaxBackoff,
+		statusCodes: map[int]struct{}{http.StatusTooManyRequests: {}},
+	}
+	client := &http.Client{Transport: transport}
+
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	elapsed := time.Since(start)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected eventual 200, got %d", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 2 {
+		t.Errorf("expected exactly 2 attempts, got %d", got)
+	}
+	if elapsed < time.Second {
+		t.Errorf("expected to honor Retry-After: 1, only waited %v", elapsed)
+	}
+}
+
+func TestRetryTransportDoesNotRetryNonIdempotentByDefault(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	transport := &retryTransport{
+		base:        http.DefaultTransport,
+		maxAttempts: defaultRetryMaxAttempts,
+		maxBackoff:  time.Millisecond,
+		statusCodes: map[int]struct{}{http.StatusServiceUnavailable: {}},
+	}
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("expected a non-idempotent POST not to be retried, got %d attempts", got)
+	}
+}
+
+func TestRetryTransportRetriesNonIdempotentWithIdempotencyKeyEcho(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&attempts, 1)
+		w.Header().Set("Idempotency-Key", r.Header.Get("Idempotency-Key"))
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	transport := &retryTransport{
+		base:        http.DefaultTransport,
+		maxAttempts: defaultRetryMaxAttempts,
+		maxBackoff:  time.Millisecond,
+		statusCodes: map[int]struct{}{http.StatusServiceUnavailable: {}},
+	}
+	client := &http.Client{Transport: transport}
+
+	req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("building request: %v", err)
+	}
+	req.Header.Set("Idempotency-Key", "abc-123")
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("body")), nil
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected eventual 200, got %d", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 2 {
+		t.Errorf("expected exactly 2 attempts, got %d", got)
+	}
+}
+
+func TestRetryTransportErrorsOnUnreplayableBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	transport := &retryTransport{
+		base:               http.DefaultTransport,
+		maxAttempts:        defaultRetryMaxAttempts,
+		maxBackoff:         time.Millisecond,
+		statusCodes:        map[int]struct{}{http.StatusServiceUnavailable: {}},
+		retryNonIdempotent: true,
+	}
+	client := &http.Client{Transport: transport}
+
+	req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("building request: %v", err)
+	}
+	req.GetBody = nil
+
+	if _, err := client.Do(req); err == nil {
+		t.Error("expected an error when the request body cannot be replayed for a retry")
+	}
+}
+
+func TestExponentialBackoffCapsLargeAttemptCounts(t *testing.T) {
+	// retry_max_attempts has no upper bound in the schema. A naive
+	// base<<uint(attempt-1) shift wraps to 0 once the shift width reaches
+	// 64, which would make a misconfigured large attempt count degrade
+	// into zero-backoff rapid-fire retries instead of respecting
+	// maxBackoff.
+	backoff := exponentialBackoff(defaultRetryBaseBackoff, defaultRetryMaxBackoff, 1000)
+	if backoff != defaultRetryMaxBackoff {
+		t.Errorf("expected backoff to be capped at %v for a large attempt count, got %v", defaultRetryMaxBackoff, backoff)
+	}
+}
+
+func TestParseTokenProviderNotConfigured(t *testing.T) {
+	d := tokenProviderResourceData(t, map[string]interface{}{})
+
+	cfg, err := parseTokenProvider(d)
+	if err != nil {
+		t.Fatalf("parseTokenProvider: %v", err)
+	}
+	if cfg != nil {
+		t.Errorf("expected nil config when token_provider is unset, got %+v", cfg)
+	}
+}
+
+// certCNFromTransport walks the base chain configureHTTPTransport builds
+// (retryTransport -> headerTransport -> *http.Transport) to find the
+// CommonName of the client certificate the innermost transport was
+// configured with, or "" if none.
+func certCNFromTransport(t *testing.T, rt http.RoundTripper) string {
+	t.Helper()
+	for {
+		switch v := rt.(type) {
+		case *retryTransport:
+			rt = v.base
+		case *headerTransport:
+			rt = v.base
+		case *http.Transport:
+			if v.TLSClientConfig == nil || len(v.TLSClientConfig.Certificates) == 0 {
+				return ""
+			}
+			cert, err := x509.ParseCertificate(v.TLSClientConfig.Certificates[0].Certificate[0])
+			if err != nil {
+				t.Fatalf("parsing certificate: %v", err)
+			}
+			return cert.Subject.CommonName
+		default:
+			t.Fatalf("unexpected transport type %T in chain", rt)
+			return ""
+		}
+	}
+}
+
+// maxAttemptsFromTransport returns the retryTransport.maxAttempts
+// configureHTTPTransport installed at the top of the chain.
+func maxAttemptsFromTransport(t *testing.T, rt http.RoundTripper) int {
+	t.Helper()
+	retryRT, ok := rt.(*retryTransport)
+	if !ok {
+		t.Fatalf("expected *retryTransport at top of chain, got %T", rt)
+	}
+	return retryRT.maxAttempts
+}
+
+func TestConfigureHTTPTransportSerializesConcurrentConfigures(t *testing.T) {
+	originalTransport, originalClient := http.DefaultTransport, http.DefaultClient
+	defer func() {
+		http.DefaultTransport, http.DefaultClient = originalTransport, originalClient
+	}()
+
+	certA, keyA := generateSelfSignedCertPEMWithCN(t, "client-A")
+	certB, keyB := generateSelfSignedCertPEMWithCN(t, "client-B")
+	dA := mtlsResourceData(t, map[string]interface{}{"client_cert_pem": string(certA), "client_key_pem": string(keyA)})
+	dB := mtlsResourceData(t, map[string]interface{}{"client_cert_pem": string(certB), "client_key_pem": string(keyB)})
+	retryA := retryConfig{maxAttempts: 3, maxBackoff: time.Millisecond, statusCodes: map[int]struct{}{http.StatusServiceUnavailable: {}}}
+	retryB := retryConfig{maxAttempts: 7, maxBackoff: time.Millisecond, statusCodes: map[int]struct{}{http.StatusServiceUnavailable: {}}}
+
+	// configureAndInspect mirrors Provider's ConfigureFunc: hold
+	// configureHTTPTransportMu across configuring the transport and reading
+	// back what a concurrent "build the client" step would observe.
+	configureAndInspect := func(d *schema.ResourceData, retry retryConfig) (cn string, maxAttempts int) {
+		configureHTTPTransportMu.Lock()
+		defer configureHTTPTransportMu.Unlock()
+
+		if err := configureHTTPTransport(nil, nil, nil, nil, retry, d); err != nil {
+			t.Errorf("configureHTTPTransport: %v", err)
+			return "", 0
+		}
+		return certCNFromTransport(t, http.DefaultTransport), maxAttemptsFromTransport(t, http.DefaultTransport)
+	}
+
+	type observation struct {
+		cn          string
+		maxAttempts int
+	}
+	const iterations = 50
+	results := make(chan observation, iterations*2)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			cn, maxAttempts := configureAndInspect(dA, retryA)
+			results <- observation{cn, maxAttempts}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			cn, maxAttempts := configureAndInspect(dB, retryB)
+			results <- observation{cn, maxAttempts}
+		}
+	}()
+	wg.Wait()
+	close(results)
+
+	for obs := range results {
+		switch {
+		case obs.cn == "client-A" && obs.maxAttempts == retryA.maxAttempts:
+		case obs.cn == "client-B" && obs.maxAttempts == retryB.maxAttempts:
+		default:
+			t.Errorf("observed a torn mix of the two concurrent configures: cert %q with maxAttempts %d", obs.cn, obs.maxAttempts)
+		}
+	}
+}
+
+func TestParseExtraHeadersMulti(t *testing.T) {
+	d := headerResourceData(t, map[string]interface{}{
+		"extra_headers_multi": []interface{}{
+			map[string]interface{}{
+				"name":   "X-Forwarded-For",
+				"values": []interface{}{"10.0.0.1", "10.0.0.2"},
+			},
+		},
+	})
+
+	headers, _, err := parseExtraHeaders(d)
+	if err != nil {
+		t.Fatalf("parseExtraHeaders: %v", err)
+	}
+
+	want := []string{"10.0.0.1", "10.0.0.2"}
+	got := headers["X-Forwarded-For"]
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected X-Forwarded-For %v, got %v", want, got)
+	}
+}
+
+func TestParseExtraHeadersRejectsKeyInBothHeadersAndMulti(t *testing.T) {
+	d := headerResourceData(t, map[string]interface{}{
+		"extra_headers": map[string]interface{}{
+			"X-Dup": "single-value",
+		},
+		"extra_headers_multi": []interface{}{
+			map[string]interface{}{
+				"name":   "X-Dup",
+				"values": []interface{}{"a", "b"},
+			},
+		},
+	})
+
+	if _, _, err := parseExtraHeaders(d); err == nil {
+		t.Error("expected an error when a key is set in both extra_headers and extra_headers_multi")
+	}
+}
+
+func TestParseHeaderRulesRejectsEmptyRule(t *testing.T) {
+	d := headerResourceData(t, map[string]interface{}{
+		"extra_header_rules": []interface{}{
+			map[string]interface{}{
+				"path_prefix": "/v3/clusters",
+			},
+		},
+	})
+
+	if _, err := parseHeaderRules(d); err == nil {
+		t.Error("expected an error when a rule sets neither headers nor headers_multi")
+	}
+}
+
+func TestParseHeaderRulesRejectsKeyInBothHeadersAndMulti(t *testing.T) {
+	d := headerResourceData(t, map[string]interface{}{
+		"extra_header_rules": []interface{}{
+			map[string]interface{}{
+				"path_prefix": "/v3/clusters",
+				"headers": map[string]interface{}{
+					"X-Dup": "single-value",
+				},
+				"headers_multi": []interface{}{
+					map[string]interface{}{
+						"name":   "X-Dup",
+						"values": []interface{}{"a", "b"},
+					},
+				},
+			},
+		},
+	})
+
+	if _, err := parseHeaderRules(d); err == nil {
+		t.Error("expected an error when a rule's header key is set in both headers and headers_multi")
+	}
+}
+
+func TestParseHeaderRulesMulti(t *testing.T) {
+	d := headerResourceData(t, map[string]interface{}{
+		"extra_header_rules": []interface{}{
+			map[string]interface{}{
+				"hosts": []interface{}{"rancher.example.com"},
+				"headers_multi": []interface{}{
+					map[string]interface{}{
+						"name":   "X-Forwarded-For",
+						"values": []interface{}{"10.0.0.1", "10.0.0.2"},
+					},
+				},
+			},
+		},
+	})
+
+	rules, err := parseHeaderRules(d)
+	if err != nil {
+		t.Fatalf("parseHeaderRules: %v", err)
+	}
+	if len(rules) != 1 {
+		t.Fatalf("expected 1 rule, got %d", len(rules))
+	}
+
+	want := []string{"10.0.0.1", "10.0.0.2"}
+	got := rules[0].headers["X-Forwarded-For"]
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected X-Forwarded-For %v, got %v", want, got)
+	}
+}
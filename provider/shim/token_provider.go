@@ -0,0 +1,305 @@
+package shim
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/helper/schema"
+)
+
+// defaultTokenTTL is the fallback expiry used when a tokenSource can't
+// determine a real one (e.g. an exec command whose output carries no
+// expiry, or a JWT that doesn't decode cleanly). It bounds how stale a
+// token can get before currentToken forces a refresh.
+const defaultTokenTTL = 5 * time.Minute
+
+// defaultTokenRefreshSkew is how long before a token's expiry currentToken
+// proactively refreshes it, used when token_provider.refresh_skew_seconds
+// is not set.
+const defaultTokenRefreshSkew = 60 * time.Second
+
+// tokenSource produces a bearer/header token value on demand. The returned
+// string is the exact value to set on the configured header (e.g. a raw
+// token for Cf-Access-Token, or "Bearer <token>" for Authorization).
+type tokenSource interface {
+	Token(ctx context.Context) (value string, expiry time.Time, err error)
+}
+
+// tokenProviderConfig bundles a configured tokenSource with how
+// headerTransport should apply it.
+type tokenProviderConfig struct {
+	source tokenSource
+	// header is the request header the token value is written to.
+	header string
+	// override, when true (the default), lets the token provider's value
+	// win over a same-named static extra_headers/extra_header_rules value.
+	// When false, an already-set static value is left alone instead.
+	override bool
+	// skew is how long before expiry the token is proactively refreshed.
+	skew time.Duration
+}
+
+// commandRunner runs an external command and returns its trimmed stdout.
+// Token sources hold one in their run field so tests can substitute a fake
+// for defaultCommandRunner.
+type commandRunner func(ctx context.Context, name string, args []string) (string, error)
+
+func defaultCommandRunner(ctx context.Context, name string, args []string) (string, error) {
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("running %s: %w", name, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// cfAccessTokenSource fetches a Cloudflare Access service token by shelling
+// out to `cloudflared access token --app=<appURL>`.
+type cfAccessTokenSource struct {
+	appURL string
+	run    commandRunner
+}
+
+func newCfAccessTokenSource(appURL string) *cfAccessTokenSource {
+	return &cfAccessTokenSource{appURL: appURL, run: defaultCommandRunner}
+}
+
+func (s *cfAccessTokenSource) Token(ctx context.Context) (string, time.Time, error) {
+	token, err := s.run(ctx, "cloudflared", []string{"access", "token", "--app=" + s.appURL})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("fetching cloudflare access token: %w", err)
+	}
+	if token == "" {
+		return "", time.Time{}, fmt.Errorf("cloudflared returned an empty access token")
+	}
+	expiry, err := jwtExpiry(token)
+	if err != nil {
+		expiry = time.Now().Add(defaultTokenTTL)
+	}
+	return token, expiry, nil
+}
+
+// execTokenSource runs a user-specified command and uses its trimmed stdout
+// as the token value. Since the output carries no expiry of its own, each
+// token is given an expiry ttl after the command ran, and currentToken
+// re-runs the command once that expiry is within the refresh skew.
+type execTokenSource struct {
+	command string
+	args    []string
+	ttl     time.Duration
+	run     commandRunner
+}
+
+func newExecTokenSource(command string, args []string, ttl time.Duration) *execTokenSource {
+	return &execTokenSource{command: command, args: args, ttl: ttl, run: defaultCommandRunner}
+}
+
+func (s *execTokenSource) Token(ctx context.Context) (string, time.Time, error) {
+	token, err := s.run(ctx, s.command, s.args)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("running exec token command: %w", err)
+	}
+	if token == "" {
+		return "", time.Time{}, fmt.Errorf("exec token command %q produced no output", s.command)
+	}
+	return token, time.Now().Add(s.ttl), nil
+}
+
+// oidcClientCredentialsTokenSource fetches a bearer token via the RFC 6749
+// client_credentials grant.
+type oidcClientCredentialsTokenSource struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scope        string
+	httpClient   *http.Client
+}
+
+func newOIDCClientCredentialsTokenSource(tokenURL, clientID, clientSecret, scope string) *oidcClientCredentialsTokenSource {
+	return &oidcClientCredentialsTokenSource{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scope:        scope,
+		// A plain client, not http.DefaultClient: by the time a
+		// tokenSource runs, http.DefaultClient may already be the
+		// headerTransport that depends on this token, and reusing it
+		// here would deadlock on headerTransport.tokenMu.
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *oidcClientCredentialsTokenSource) Token(ctx context.Context) (string, time.Time, error) {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {s.clientID},
+		"client_secret": {s.clientSecret},
+	}
+	if s.scope != "" {
+		form.Set("scope", s.scope)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("building OIDC token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("requesting OIDC token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("OIDC token endpoint %s returned %s", s.tokenURL, resp.Status)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", time.Time{}, fmt.Errorf("decoding OIDC token response: %w", err)
+	}
+	if body.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("OIDC token response from %s is missing access_token", s.tokenURL)
+	}
+
+	tokenType := body.TokenType
+	if tokenType == "" {
+		tokenType = "Bearer"
+	}
+	expiry := time.Now().Add(defaultTokenTTL)
+	if body.ExpiresIn > 0 {
+		expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return tokenType + " " + body.AccessToken, expiry, nil
+}
+
+// jwtExpiry decodes the "exp" claim out of a JWT's payload segment, without
+// verifying the token's signature (the token was already obtained from a
+// trusted source; this is only used to schedule a proactive refresh).
+func jwtExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) < 2 {
+		return time.Time{}, fmt.Errorf("not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("decoding JWT payload: %w", err)
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("decoding JWT claims: %w", err)
+	}
+	if claims.Exp == 0 {
+		return time.Time{}, fmt.Errorf("JWT has no exp claim")
+	}
+	return time.Unix(claims.Exp, 0), nil
+}
+
+// parseTokenProvider reads the token_provider schema block into a
+// tokenProviderConfig. It returns (nil, nil) if token_provider is not set.
+func parseTokenProvider(d *schema.ResourceData) (*tokenProviderConfig, error) {
+	v, ok := d.GetOk("token_provider")
+	if !ok {
+		return nil, nil
+	}
+	raw := v.([]interface{})
+	if len(raw) == 0 || raw[0] == nil {
+		return nil, nil
+	}
+	block := raw[0].(map[string]interface{})
+
+	providerType := block["type"].(string)
+
+	skew := defaultTokenRefreshSkew
+	if secs, ok := block["refresh_skew_seconds"].(int); ok && secs > 0 {
+		skew = time.Duration(secs) * time.Second
+	}
+
+	override := true
+	if v, ok := block["override"].(bool); ok {
+		override = v
+	}
+
+	cfg := &tokenProviderConfig{override: override, skew: skew}
+
+	switch providerType {
+	case "cf_access":
+		sub, err := tokenProviderSubBlock(block, "cf_access")
+		if err != nil {
+			return nil, err
+		}
+		appURL, _ := sub["app_url"].(string)
+		if appURL == "" {
+			return nil, fmt.Errorf("token_provider.cf_access.app_url is required when type is \"cf_access\"")
+		}
+		cfg.source = newCfAccessTokenSource(appURL)
+		cfg.header = "Cf-Access-Token"
+
+	case "oidc_client_credentials":
+		sub, err := tokenProviderSubBlock(block, "oidc_client_credentials")
+		if err != nil {
+			return nil, err
+		}
+		tokenURL, _ := sub["token_url"].(string)
+		clientID, _ := sub["client_id"].(string)
+		clientSecret, _ := sub["client_secret"].(string)
+		scope, _ := sub["scope"].(string)
+		if tokenURL == "" || clientID == "" || clientSecret == "" {
+			return nil, fmt.Errorf("token_provider.oidc_client_credentials requires token_url, client_id and client_secret when type is \"oidc_client_credentials\"")
+		}
+		cfg.source = newOIDCClientCredentialsTokenSource(tokenURL, clientID, clientSecret, scope)
+		cfg.header = "Authorization"
+
+	case "exec":
+		sub, err := tokenProviderSubBlock(block, "exec")
+		if err != nil {
+			return nil, err
+		}
+		command, _ := sub["command"].(string)
+		if command == "" {
+			return nil, fmt.Errorf("token_provider.exec.command is required when type is \"exec\"")
+		}
+		var args []string
+		for _, a := range sub["args"].([]interface{}) {
+			args = append(args, a.(string))
+		}
+		ttl := defaultTokenTTL
+		if secs, ok := sub["ttl_seconds"].(int); ok && secs > 0 {
+			ttl = time.Duration(secs) * time.Second
+		}
+		cfg.source = newExecTokenSource(command, args, ttl)
+		cfg.header = "Authorization"
+
+	default:
+		return nil, fmt.Errorf("unsupported token_provider.type %q: must be one of cf_access, oidc_client_credentials, exec", providerType)
+	}
+
+	if header, ok := block["header"].(string); ok && header != "" {
+		cfg.header = header
+	}
+
+	return cfg, nil
+}
+
+// tokenProviderSubBlock returns the single nested config block for the
+// given token_provider sub-key, erroring if it's missing.
+func tokenProviderSubBlock(block map[string]interface{}, key string) (map[string]interface{}, error) {
+	raw, ok := block[key].([]interface{})
+	if !ok || len(raw) == 0 || raw[0] == nil {
+		return nil, fmt.Errorf("token_provider.%s must be set when type is %q", key, key)
+	}
+	return raw[0].(map[string]interface{}), nil
+}